@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EncoderBackend builds the ffmpeg arguments needed to drive a particular
+// video encoder (software or hardware-accelerated). HLSEncoder, MP4Encoder
+// and RTSPEncoder all delegate the codec-specific portion of their ffmpeg
+// command line to whichever backend was selected on the command line.
+type EncoderBackend interface {
+	// Name is the ffmpeg encoder name, e.g. "libx264" or "h264_nvenc". It
+	// doubles as the value accepted by --video-codec/--hwaccel and the key
+	// looked up in the `ffmpeg -encoders` probe.
+	Name() string
+	// GlobalArgs returns ffmpeg arguments that must appear before the input
+	// (-i), such as hardware device initialization. Returns nil for
+	// software encoders.
+	GlobalArgs() []string
+	// VideoArgs returns the -vcodec and associated pixel-format/preset/
+	// rate-control arguments for this backend. quality is the CRF-like
+	// value from --crf; lowLatency requests zero-latency tuning, used by
+	// MP4Encoder/RTSPEncoder but not HLSEncoder.
+	VideoArgs(quality int, lowLatency bool) []string
+}
+
+type libx264Backend struct{}
+
+func (libx264Backend) Name() string         { return "libx264" }
+func (libx264Backend) GlobalArgs() []string { return nil }
+func (libx264Backend) VideoArgs(quality int, lowLatency bool) []string {
+	preset := "veryfast"
+	if lowLatency {
+		preset = "ultrafast"
+	}
+	args := []string{
+		"-vcodec", "libx264",
+		"-preset", preset,
+	}
+	if lowLatency {
+		args = append(args, "-tune", "zerolatency")
+	}
+	return append(args,
+		"-g", "250",
+		"-crf", strconv.Itoa(quality),
+		"-pix_fmt", "yuv420p",
+	)
+}
+
+type vaapiBackend struct{}
+
+func (vaapiBackend) Name() string { return "h264_vaapi" }
+func (vaapiBackend) GlobalArgs() []string {
+	return []string{"-vaapi_device", "/dev/dri/renderD128"}
+}
+func (vaapiBackend) VideoArgs(quality int, lowLatency bool) []string {
+	return []string{
+		"-vf", "format=nv12,hwupload",
+		"-vcodec", "h264_vaapi",
+		"-g", "250",
+		"-qp", strconv.Itoa(quality),
+	}
+}
+
+type nvencBackend struct{}
+
+func (nvencBackend) Name() string         { return "h264_nvenc" }
+func (nvencBackend) GlobalArgs() []string { return nil }
+func (nvencBackend) VideoArgs(quality int, lowLatency bool) []string {
+	preset := "p4"
+	if lowLatency {
+		preset = "p1"
+	}
+	return []string{
+		"-vcodec", "h264_nvenc",
+		"-preset", preset,
+		"-g", "250",
+		"-rc", "vbr",
+		"-cq", strconv.Itoa(quality),
+		"-pix_fmt", "yuv420p",
+	}
+}
+
+type qsvBackend struct{}
+
+func (qsvBackend) Name() string { return "h264_qsv" }
+func (qsvBackend) GlobalArgs() []string {
+	return []string{"-init_hw_device", "qsv=hw", "-filter_hw_device", "hw"}
+}
+func (qsvBackend) VideoArgs(quality int, lowLatency bool) []string {
+	return []string{
+		"-vf", "format=nv12,hwupload=extra_hw_frames=64",
+		"-vcodec", "h264_qsv",
+		"-g", "250",
+		"-global_quality", strconv.Itoa(quality),
+	}
+}
+
+// encoderBackends maps the --video-codec/--hwaccel flag value to its backend.
+var encoderBackends = map[string]EncoderBackend{
+	"libx264":    libx264Backend{},
+	"h264_vaapi": vaapiBackend{},
+	"h264_nvenc": nvencBackend{},
+	"h264_qsv":   qsvBackend{},
+}
+
+// probeEncoders runs `ffmpeg -hide_banner -encoders` and returns the set of
+// encoder names ffmpeg reports as available, so we can fall back gracefully
+// when a requested hardware encoder isn't present on the host.
+func probeEncoders(ffmpegBinPath string) (map[string]bool, error) {
+	out, err := exec.Command(ffmpegBinPath, "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	available := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Lines of interest look like " V..... libx264  H.264 / AVC / ..."
+		if len(fields) < 2 || !strings.ContainsAny(fields[0], "V") {
+			continue
+		}
+		available[fields[1]] = true
+	}
+	return available, nil
+}
+
+// selectEncoderBackend resolves the requested backend name, falling back to
+// libx264 with a warning if the name is unknown or ffmpeg doesn't report it
+// as available.
+func selectEncoderBackend(name, ffmpegBinPath string) EncoderBackend {
+	if name == "" {
+		name = "libx264"
+	}
+
+	backend, ok := encoderBackends[name]
+	if !ok {
+		logrus.WithField("videoCodec", name).Warn("unknown video codec requested, falling back to libx264")
+		return libx264Backend{}
+	}
+	if backend.Name() == "libx264" {
+		return backend
+	}
+
+	available, err := probeEncoders(ffmpegBinPath)
+	if err != nil {
+		logrus.WithError(err).Warn("could not probe ffmpeg encoders, falling back to libx264")
+		return libx264Backend{}
+	}
+	if !available[backend.Name()] {
+		logrus.WithField("videoCodec", backend.Name()).Warn("requested encoder not available in this ffmpeg build, falling back to libx264")
+		return libx264Backend{}
+	}
+	return backend
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// BroadcastManager fans a single VNC capture feed out to several sinks at
+// once (local HLS directory, RTMP, SRT, ...) using ffmpeg's tee muxer, and
+// lets sinks be added or removed at runtime without dropping the underlying
+// recording: adding/removing a sink kills and restarts the ffmpeg pipeline
+// with the updated target list, re-piping the same PPM stream into it.
+type BroadcastManager struct {
+	FFMpegBinPath      string
+	Framerate          int
+	ConstantRateFactor int
+	Backend            EncoderBackend
+
+	mu     sync.Mutex
+	sinks  []string
+	cmd    *exec.Cmd
+	input  io.WriteCloser
+	closed bool
+}
+
+// NewBroadcastManager creates a BroadcastManager with no sinks configured;
+// call Start to add the first one.
+func NewBroadcastManager(ffmpegBinPath string, framerate, crf int, backend EncoderBackend) *BroadcastManager {
+	if backend == nil {
+		backend = libx264Backend{}
+	}
+	return &BroadcastManager{
+		FFMpegBinPath:      ffmpegBinPath,
+		Framerate:          framerate,
+		ConstantRateFactor: crf,
+		Backend:            backend,
+	}
+}
+
+// Start adds sink to the set of active broadcast targets and rebuilds the
+// ffmpeg pipeline to include it. sink is one of the `--sink` values, e.g.
+// "hls:/data", "rtmp://a.b/live/key" or "srt://host:port".
+func (b *BroadcastManager) Start(sink string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, existing := range b.sinks {
+		if existing == sink {
+			return nil
+		}
+	}
+	b.sinks = append(b.sinks, sink)
+	return b.rebuildLocked()
+}
+
+// Stop removes sink from the set of active broadcast targets and rebuilds
+// the pipeline. If it was the last sink, the pipeline is torn down and
+// IsActive returns false.
+func (b *BroadcastManager) Stop(sink string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	kept := b.sinks[:0]
+	for _, existing := range b.sinks {
+		if existing != sink {
+			kept = append(kept, existing)
+		}
+	}
+	b.sinks = kept
+
+	if len(b.sinks) == 0 {
+		b.killLocked()
+		return nil
+	}
+	return b.rebuildLocked()
+}
+
+// IsActive reports whether an ffmpeg broadcast pipeline is currently
+// running. It is false after the last sink is stopped, after Close, and
+// when the most recent rebuild failed to start ffmpeg.
+func (b *BroadcastManager) IsActive() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.cmd != nil
+}
+
+// rebuildLocked kills any running ffmpeg process and starts a new one with
+// the current sink list. Callers must hold b.mu.
+func (b *BroadcastManager) rebuildLocked() error {
+	b.killLocked()
+
+	targets := make([]string, 0, len(b.sinks))
+	for _, sink := range b.sinks {
+		targets = append(targets, teeTarget(sink))
+	}
+
+	args := []string{
+		"-f", "image2pipe",
+		"-vcodec", "ppm",
+		"-r", strconv.Itoa(b.Framerate),
+		"-an",
+		"-y",
+	}
+	args = append(args, b.Backend.GlobalArgs()...)
+	// Frames now arrive at a variable rate (--idle-fps/--active-fps), so derive
+	// PTS from wall clock instead of assuming the constant -r above and let the
+	// output be variable framerate rather than stretching/duplicating to a CFR.
+	args = append(args, "-use_wallclock_as_timestamps", "1")
+	args = append(args, "-i", "-")
+	args = append(args, b.Backend.VideoArgs(b.ConstantRateFactor, true)...)
+	args = append(args, "-vsync", "vfr")
+	args = append(args,
+		"-f", "tee",
+		"-map", "0:v",
+		strings.Join(targets, "|"),
+	)
+
+	cmd := exec.Command(b.FFMpegBinPath, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	encInput, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("can't get ffmpeg input pipe: %w", err)
+	}
+
+	logrus.WithField("sinks", b.sinks).Info("Starting broadcast pipeline")
+	logrus.Infof("launching binary: %v", cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("can't start ffmpeg: %w", err)
+	}
+
+	b.cmd = cmd
+	b.input = encInput
+	b.closed = false
+	return nil
+}
+
+// killLocked stops the running ffmpeg process, if any. Callers must hold b.mu.
+func (b *BroadcastManager) killLocked() {
+	if b.cmd == nil {
+		return
+	}
+	if b.input != nil {
+		_ = b.input.Close()
+	}
+	_ = b.cmd.Process.Kill()
+	_ = b.cmd.Wait()
+	b.cmd = nil
+	b.input = nil
+}
+
+// Encode writes a frame to whichever pipeline is currently active.
+func (b *BroadcastManager) Encode(img image.Image) {
+	b.mu.Lock()
+	input := b.input
+	closed := b.closed
+	b.mu.Unlock()
+
+	if input == nil || closed {
+		return
+	}
+
+	if err := encodePPM(input, img); err != nil {
+		logrus.WithError(err).Error("error while encoding image.")
+	}
+}
+
+// Close tears the broadcast pipeline down for good.
+func (b *BroadcastManager) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.killLocked()
+}
+
+// teeTarget turns a `--sink` value into an ffmpeg tee muxer target
+// specification, e.g. "hls:/data" -> "[f=hls:hls_time=10:hls_list_size=6]/data/stream.m3u8".
+func teeTarget(sink string) string {
+	switch {
+	case strings.HasPrefix(sink, "hls:"):
+		dir := strings.TrimPrefix(sink, "hls:")
+		return fmt.Sprintf("[f=hls:hls_time=10:hls_list_size=6]%s", filepath.Join(dir, "stream.m3u8"))
+	case strings.HasPrefix(sink, "rtmp://"):
+		return fmt.Sprintf("[f=flv]%s", sink)
+	case strings.HasPrefix(sink, "srt://"):
+		return fmt.Sprintf("[f=mpegts]%s", sink)
+	default:
+		// Treat as a local MP4 path.
+		return fmt.Sprintf("[f=mp4]%s", sink)
+	}
+}
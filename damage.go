@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	vnc "github.com/amitbet/vnc2video"
+)
+
+// DamageTracker accumulates dirty-rectangle area from FramebufferUpdate
+// messages so the capture loop can drop to --idle-fps instead of
+// re-encoding an unchanged desktop at --active-fps, the way Encode()
+// previously did regardless of whether the framebuffer had actually
+// changed.
+type DamageTracker struct {
+	mu          sync.Mutex
+	dirtyPixels int
+	threshold   int
+	lastDirty   time.Time
+	idleAfter   time.Duration
+}
+
+// NewDamageTracker creates a tracker that considers the session active for
+// idleAfter after any update, or for as long as accumulated dirty area stays
+// above threshold pixels.
+func NewDamageTracker(threshold int, idleAfter time.Duration) *DamageTracker {
+	return &DamageTracker{
+		threshold: threshold,
+		idleAfter: idleAfter,
+		lastDirty: time.Now(),
+	}
+}
+
+// Observe records the dirty rectangles carried by a FramebufferUpdate
+// server message. Other message types are ignored.
+func (d *DamageTracker) Observe(msg vnc.ServerMessage) {
+	update, ok := msg.(*vnc.FramebufferUpdate)
+	if !ok {
+		return
+	}
+
+	area := 0
+	for _, rect := range update.Rects {
+		area += int(rect.Width) * int(rect.Height)
+	}
+	if area == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	d.dirtyPixels += area
+	d.lastDirty = time.Now()
+	d.mu.Unlock()
+}
+
+// Active reports whether the desktop should currently be considered
+// actively changing, i.e. whether the capture loop should run at
+// --active-fps rather than --idle-fps.
+func (d *DamageTracker) Active() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dirtyPixels >= d.threshold {
+		return true
+	}
+	return time.Since(d.lastDirty) < d.idleAfter
+}
+
+// Reset clears the accumulated dirty pixel count, e.g. after a frame has
+// been encoded.
+func (d *DamageTracker) Reset() {
+	d.mu.Lock()
+	d.dirtyPixels = 0
+	d.mu.Unlock()
+}
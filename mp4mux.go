@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// mp4Timescale is the time base (units per second) used for every duration
+// and the movie/media headers below.
+const mp4Timescale = 90000
+
+// mp4Muxer incrementally writes a raw H.264 Annex-B bitstream out as a
+// single-track, non-fragmented MP4 file: mdat is appended to as samples
+// arrive, and moov (which needs every sample's size, offset and duration
+// up front) is built and appended once Finalize is called.
+//
+// This replaces a third-party muxer dependency that turned out to be a
+// low-level box-writing library rather than an actual muxer; the box
+// layout implemented here is the minimal one a standard player needs:
+// ftyp/mdat/moov with a single avc1 video track.
+type mp4Muxer struct {
+	file   *os.File
+	width  int
+	height int
+	fps    int
+
+	mdatStart int64
+	mdatSize  int64
+
+	sps, pps []byte
+	entries  []mp4SampleEntry
+}
+
+// mp4SampleEntry records the bookkeeping a sample table needs for one
+// access unit once it has been appended to mdat.
+type mp4SampleEntry struct {
+	offset   int64
+	size     uint32
+	duration uint32
+	keyFrame bool
+}
+
+// newMp4Muxer creates a muxer that writes into file, which must be empty
+// and opened for writing. width/height/fps describe the video track.
+func newMp4Muxer(file *os.File, width, height, fps int) (*mp4Muxer, error) {
+	m := &mp4Muxer{file: file, width: width, height: height, fps: fps}
+
+	ftyp := buildBox("ftyp", concat(
+		[]byte("isom"),
+		be32(0x200),
+		[]byte("isom"), []byte("iso2"), []byte("avc1"), []byte("mp41"),
+	))
+	if _, err := file.Write(ftyp); err != nil {
+		return nil, fmt.Errorf("could not write ftyp box: %w", err)
+	}
+
+	pos, err := file.Seek(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	m.mdatStart = pos
+	// Placeholder 32-bit mdat box header (size, "mdat"); patched in Finalize
+	// once the payload size is known.
+	if _, err := file.Write(concat(be32(0), []byte("mdat"))); err != nil {
+		return nil, fmt.Errorf("could not write mdat header: %w", err)
+	}
+	return m, nil
+}
+
+// WriteSample takes one Annex-B access unit, extracts/remembers SPS and PPS
+// the first time they're seen, appends the slice NAL units to mdat as
+// length-prefixed (AVCC) data, and records the sample for the sample table.
+func (m *mp4Muxer) WriteSample(annexB []byte, duration uint32) error {
+	nals := splitAnnexB(annexB)
+	if len(nals) == 0 {
+		return nil
+	}
+
+	var payload bytes.Buffer
+	keyFrame := false
+	for _, nal := range nals {
+		if len(nal) == 0 {
+			continue
+		}
+		switch nalUnitType(nal[0]) {
+		case 7: // SPS
+			if m.sps == nil {
+				m.sps = append([]byte(nil), nal...)
+			}
+			continue
+		case 8: // PPS
+			if m.pps == nil {
+				m.pps = append([]byte(nil), nal...)
+			}
+			continue
+		case 9: // access unit delimiter, not needed in AVCC framing
+			continue
+		case 5:
+			keyFrame = true
+		}
+		payload.Write(be32(uint32(len(nal))))
+		payload.Write(nal)
+	}
+	if payload.Len() == 0 {
+		return nil
+	}
+
+	offset, err := m.file.Seek(0, 1)
+	if err != nil {
+		return fmt.Errorf("could not read mdat write offset: %w", err)
+	}
+	if _, err := m.file.Write(payload.Bytes()); err != nil {
+		return fmt.Errorf("could not append sample to mdat: %w", err)
+	}
+
+	m.entries = append(m.entries, mp4SampleEntry{
+		offset:   offset,
+		size:     uint32(payload.Len()),
+		duration: duration,
+		keyFrame: keyFrame,
+	})
+	return nil
+}
+
+// Finalize patches the mdat box size and appends the moov box, completing
+// the file. The muxer must not be used afterwards.
+func (m *mp4Muxer) Finalize() error {
+	end, err := m.file.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	m.mdatSize = end - m.mdatStart
+	if _, err := m.file.WriteAt(be32(uint32(m.mdatSize)), m.mdatStart); err != nil {
+		return fmt.Errorf("could not patch mdat size: %w", err)
+	}
+
+	if m.sps == nil || m.pps == nil {
+		return fmt.Errorf("no SPS/PPS seen before Finalize; cannot build avcC")
+	}
+
+	moov := m.buildMoov()
+	if _, err := m.file.Write(moov); err != nil {
+		return fmt.Errorf("could not write moov box: %w", err)
+	}
+	return nil
+}
+
+func (m *mp4Muxer) buildMoov() []byte {
+	var stts, stsz, stco, stss bytes.Buffer
+	sampleCount := uint32(len(m.entries))
+
+	stts.Write(be32(sampleCount))
+	for _, e := range m.entries {
+		stts.Write(be32(1))
+		stts.Write(be32(e.duration))
+	}
+
+	stsz.Write(be32(0)) // sample_size == 0: sizes are per-entry below
+	stsz.Write(be32(sampleCount))
+	for _, e := range m.entries {
+		stsz.Write(be32(e.size))
+	}
+
+	stco.Write(be32(sampleCount))
+	for _, e := range m.entries {
+		stco.Write(be32(uint32(e.offset)))
+	}
+
+	keyCount := uint32(0)
+	for _, e := range m.entries {
+		if e.keyFrame {
+			keyCount++
+		}
+	}
+	stss.Write(be32(keyCount))
+	for i, e := range m.entries {
+		if e.keyFrame {
+			stss.Write(be32(uint32(i + 1)))
+		}
+	}
+
+	duration := uint32(0)
+	for _, e := range m.entries {
+		duration += e.duration
+	}
+
+	mvhd := buildFullBox("mvhd", 0, 0, concat(
+		be32(0), be32(0), // creation/modification time
+		be32(mp4Timescale), be32(duration),
+		be32(0x00010000),      // rate, 1.0
+		be16(0x0100), be16(0), // volume, 1.0; reserved
+		be32(0), be32(0), // reserved
+		identityMatrix(),
+		bytes.Repeat([]byte{0}, 24), // predefined
+		be32(2),                     // next_track_ID
+	))
+
+	tkhd := buildFullBox("tkhd", 0, 0x7, concat(
+		be32(0), be32(0), // creation/modification time
+		be32(1),          // track_ID
+		be32(0),          // reserved
+		be32(duration),   // duration
+		be32(0), be32(0), // reserved
+		be16(0), be16(0), // layer, alternate_group
+		be16(0), be16(0), // volume, reserved
+		identityMatrix(),
+		be32(uint32(m.width)<<16), be32(uint32(m.height)<<16),
+	))
+
+	mdhd := buildFullBox("mdhd", 0, 0, concat(
+		be32(0), be32(0),
+		be32(mp4Timescale), be32(duration),
+		be16(0x55c4), be16(0), // language "und", pre_defined
+	))
+
+	hdlr := buildFullBox("hdlr", 0, 0, concat(
+		be32(0), []byte("vide"),
+		be32(0), be32(0), be32(0),
+		[]byte("VideoHandler\x00"),
+	))
+
+	vmhd := buildFullBox("vmhd", 0, 1, concat(
+		be16(0), be16(0), be16(0), be16(0),
+	))
+
+	url := buildFullBox("url ", 0, 1, nil)
+	dref := buildFullBox("dref", 0, 0, concat(be32(1), url))
+	dinf := buildBox("dinf", dref)
+
+	avcC := buildBox("avcC", concat(
+		[]byte{1, m.sps[1], m.sps[2], m.sps[3]},
+		[]byte{0xff}, // reserved(6)=1 + lengthSizeMinusOne=3 (4-byte lengths)
+		[]byte{0xe1}, // reserved(3)=1 + numOfSPS=1
+		be16(uint16(len(m.sps))), m.sps,
+		[]byte{1}, // numOfPPS
+		be16(uint16(len(m.pps))), m.pps,
+	))
+	avc1 := buildBox("avc1", concat(
+		bytes.Repeat([]byte{0}, 6), be16(1), // reserved, data_reference_index
+		be16(0), be16(0), // pre_defined, reserved
+		bytes.Repeat([]byte{0}, 12), // pre_defined
+		be16(uint16(m.width)), be16(uint16(m.height)),
+		be32(0x00480000), be32(0x00480000), // horiz/vert resolution, 72dpi
+		be32(0),                     // reserved
+		be16(1),                     // frame_count
+		bytes.Repeat([]byte{0}, 32), // compressorname
+		be16(0x0018), be16(0xffff),  // depth, pre_defined
+		avcC,
+	))
+	stsd := buildFullBox("stsd", 0, 0, concat(be32(1), avc1))
+
+	stbl := buildBox("stbl", concat(
+		stsd,
+		buildFullBox("stts", 0, 0, stts.Bytes()),
+		// One sample per chunk (stco has one offset per sample), so a single
+		// entry covering every chunk from 1 onward is enough.
+		buildFullBox("stsc", 0, 0, concat(be32(1), be32(1), be32(1), be32(1))),
+		buildFullBox("stsz", 0, 0, stsz.Bytes()),
+		buildFullBox("stco", 0, 0, stco.Bytes()),
+		buildFullBox("stss", 0, 0, stss.Bytes()),
+	))
+	minf := buildBox("minf", concat(vmhd, dinf, stbl))
+	mdia := buildBox("mdia", concat(mdhd, hdlr, minf))
+	trak := buildBox("trak", concat(tkhd, mdia))
+
+	return buildBox("moov", concat(mvhd, trak))
+}
+
+// --- small box-writing helpers -------------------------------------------
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func concat(parts ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, p := range parts {
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
+
+// buildBox wraps payload in a standard ISO-BMFF box: a 32-bit size
+// (including the 8-byte header) followed by the 4-character type.
+func buildBox(boxType string, payload []byte) []byte {
+	size := uint32(8 + len(payload))
+	return concat(be32(size), []byte(boxType), payload)
+}
+
+// buildFullBox wraps payload in a "full box": a box plus an 8-bit version
+// and 24-bit flags field ahead of the payload.
+func buildFullBox(boxType string, version uint8, flags uint32, payload []byte) []byte {
+	header := be32(uint32(version)<<24 | (flags & 0x00ffffff))
+	return buildBox(boxType, concat(header, payload))
+}
+
+// identityMatrix returns the unity transformation matrix used by mvhd/tkhd.
+func identityMatrix() []byte {
+	return concat(
+		be32(0x00010000), be32(0), be32(0),
+		be32(0), be32(0x00010000), be32(0),
+		be32(0), be32(0), be32(0x40000000),
+	)
+}
+
+// nalUnitType returns the low 5 bits of an H.264 NAL header byte.
+func nalUnitType(b byte) byte {
+	return b & 0x1f
+}
+
+// splitAnnexB splits an Annex-B byte stream (NAL units separated by
+// 00 00 01 / 00 00 00 01 start codes) into individual NAL units with the
+// start codes stripped.
+func splitAnnexB(data []byte) [][]byte {
+	var markers []int
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			markers = append(markers, i)
+		}
+	}
+	if len(markers) == 0 {
+		return nil
+	}
+
+	nals := make([][]byte, 0, len(markers))
+	for i, marker := range markers {
+		start := marker + 3
+		end := len(data)
+		if i+1 < len(markers) {
+			end = markers[i+1]
+			for end > start && data[end-1] == 0 {
+				end--
+			}
+		}
+		if end > start {
+			nals = append(nals, data[start:end])
+		}
+	}
+	return nals
+}
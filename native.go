@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	vnc "github.com/amitbet/vnc2video"
+	"github.com/pion/mediadevices/pkg/codec"
+	"github.com/pion/mediadevices/pkg/codec/x264"
+	"github.com/pion/mediadevices/pkg/io/video"
+	"github.com/pion/mediadevices/pkg/prop"
+	"github.com/sirupsen/logrus"
+)
+
+// Encoder is implemented by the native (cgo, non-ffmpeg) encoding pipeline.
+// Unlike HLSEncoder/MP4Encoder/RTSPEncoder, which pipe PPM frames into an
+// ffmpeg subprocess, a native Encoder encodes frames in-process and never
+// shells out, so a --backend native deployment needs nothing but the Go
+// binary on the host.
+type Encoder interface {
+	WriteFrame(img image.Image) error
+	Close()
+}
+
+// nativeCodecBuilders maps the codec name accepted by --video-codec in
+// native mode to the mediadevices param builder for it. Only h264 is
+// registered: mp4mux.go only knows how to write AVC sample/avcC framing,
+// and the vpx/svtav1 packages both carry cgo pkg-config requirements
+// (libvpx/SvtAv1Enc), so importing them for codecs Init() already rejects
+// would break the build for everyone, including ffmpeg-backend users who
+// never touch native mode. Add them back once mp4mux.go can mux vp9/av1.
+var nativeCodecBuilders = map[string]func(bitrate int) (codecParams, error){
+	"h264": func(bitrate int) (codecParams, error) {
+		p, err := x264.NewParams()
+		if err != nil {
+			return nil, err
+		}
+		p.BitRate = bitrate
+		return &p, nil
+	},
+}
+
+// codecParams is the subset of mediadevices' per-codec Params types we rely
+// on: building a video encoder bound to a video.Reader.
+type codecParams interface {
+	BuildVideoEncoder(r video.Reader, p prop.Media) (codec.ReadCloser, error)
+}
+
+// NativeEncoder implements Encoder by feeding frames straight into a
+// mediadevices video encoder (x264) and muxing the encoded bitstream into
+// an MP4 file with our own minimal box muxer (mp4mux.go), with no ffmpeg
+// subprocess and no PPM serialization round-trip: frames are converted
+// directly from the VNC canvas's pixel format to YCbCr in memory.
+//
+// The mp4 muxer only understands AVC (H.264) sample/avcC framing, so
+// Init rejects --video-codec vp9/av1 in native mode rather than produce a
+// file with no video track.
+type NativeEncoder struct {
+	Codec      string // "h264" (default); vp9/av1 are not yet muxable natively
+	Width      int
+	Height     int
+	Framerate  int
+	Bitrate    int
+	OutputFile string
+
+	mu        sync.Mutex
+	closed    bool
+	frames    chan timedFrame
+	durations chan uint32
+	done      chan struct{}
+	file      *os.File
+	mux       *mp4Muxer
+	rdEnc     codec.ReadCloser
+}
+
+// timedFrame pairs a captured frame with the wall-clock time WriteFrame was
+// called, so the gap between consecutive frames' capture times can be used
+// as each encoded sample's real duration instead of a value derived from
+// the static --framerate flag, which no longer reflects the variable
+// --idle-fps/--active-fps capture rate.
+type timedFrame struct {
+	img image.Image
+	at  time.Time
+}
+
+// Init builds the codec + muxer and starts the background encode loop.
+func (n *NativeEncoder) Init() error {
+	if n.Framerate == 0 {
+		n.Framerate = 12
+	}
+	if n.Bitrate == 0 {
+		n.Bitrate = 1_000_000
+	}
+	if n.Codec == "" || n.Codec == "libx264" {
+		// --video-codec defaults to the ffmpeg backend's "libx264"; map that
+		// onto the native backend's equivalent.
+		n.Codec = "h264"
+	}
+
+	if n.Codec != "h264" {
+		return fmt.Errorf("native backend can only mux h264 right now, got %q", n.Codec)
+	}
+	build, ok := nativeCodecBuilders[n.Codec]
+	if !ok {
+		return fmt.Errorf("unsupported native codec %q", n.Codec)
+	}
+	params, err := build(n.Bitrate)
+	if err != nil {
+		return fmt.Errorf("could not configure %s encoder: %w", n.Codec, err)
+	}
+
+	n.frames = make(chan timedFrame, 2)
+	n.durations = make(chan uint32, 2)
+	n.done = make(chan struct{})
+
+	// nominalDuration is used for the very first frame, which has no
+	// predecessor to measure a real gap against.
+	nominalDuration := uint32(mp4Timescale / n.Framerate)
+	lastAt := time.Time{}
+	reader := video.ReaderFunc(func() (image.Image, func(), error) {
+		ft, ok := <-n.frames
+		if !ok {
+			return nil, func() {}, io.EOF
+		}
+
+		duration := nominalDuration
+		if !lastAt.IsZero() {
+			if d := uint32(ft.at.Sub(lastAt).Seconds() * mp4Timescale); d > 0 {
+				duration = d
+			}
+		}
+		lastAt = ft.at
+		n.durations <- duration
+
+		return rgbToYCbCr(ft.img), func() {}, nil
+	})
+
+	enc, err := params.BuildVideoEncoder(reader, prop.Media{
+		Video: prop.Video{
+			Width:     n.Width,
+			Height:    n.Height,
+			FrameRate: float32(n.Framerate),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not build %s encoder: %w", n.Codec, err)
+	}
+	n.rdEnc = enc
+
+	file, err := os.Create(n.OutputFile)
+	if err != nil {
+		return fmt.Errorf("could not create output file: %w", err)
+	}
+	mux, err := newMp4Muxer(file, n.Width, n.Height, n.Framerate)
+	if err != nil {
+		return fmt.Errorf("could not start mp4 muxer: %w", err)
+	}
+	n.file = file
+	n.mux = mux
+
+	go n.pump()
+	return nil
+}
+
+// pump drains encoded samples off the codec and writes them to the MP4
+// muxer until the encoder is closed. Each sample's duration is the real
+// wall-clock gap between the WriteFrame calls that produced it and its
+// predecessor (see timedFrame), not a value derived from --framerate, since
+// frames now arrive at the variable --idle-fps/--active-fps capture rate.
+func (n *NativeEncoder) pump() {
+	defer close(n.done)
+	for {
+		sample, release, err := n.rdEnc.Read()
+		if err != nil {
+			if err != io.EOF {
+				logrus.WithError(err).Error("native encoder read error")
+			}
+			return
+		}
+		duration := <-n.durations
+		if err := n.mux.WriteSample(sample, duration); err != nil {
+			logrus.WithError(err).Error("error writing mp4 sample")
+		}
+		release()
+	}
+}
+
+// WriteFrame queues img, stamped with its capture time, for the encoder,
+// which converts it to YCbCr; it satisfies the Encoder interface.
+func (n *NativeEncoder) WriteFrame(img image.Image) error {
+	n.mu.Lock()
+	closed := n.closed
+	n.mu.Unlock()
+	if closed {
+		return nil
+	}
+
+	select {
+	case n.frames <- timedFrame{img: img, at: time.Now()}:
+	default:
+		// Encoder is falling behind; drop this frame rather than block the
+		// capture loop.
+	}
+	return nil
+}
+
+// Encode adapts WriteFrame to the VideoEncoder interface used by doRecord.
+func (n *NativeEncoder) Encode(img image.Image) {
+	if err := n.WriteFrame(img); err != nil {
+		logrus.WithError(err).Error("error while encoding image.")
+	}
+}
+
+// Close stops accepting frames, drains the encoder and finalizes the MP4
+// file.
+func (n *NativeEncoder) Close() {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	n.mu.Unlock()
+
+	close(n.frames)
+	<-n.done
+	if n.rdEnc != nil {
+		_ = n.rdEnc.Close()
+	}
+	if n.mux != nil {
+		if err := n.mux.Finalize(); err != nil {
+			logrus.WithError(err).Error("error finalizing mp4 file")
+		}
+	}
+	if n.file != nil {
+		_ = n.file.Close()
+	}
+}
+
+// rgbToYCbCr converts a VNC framebuffer image directly to YCbCr (4:2:0),
+// skipping the PPM-over-pipe round-trip the ffmpeg backend needs.
+func rgbToYCbCr(img image.Image) *image.YCbCr {
+	bounds := img.Bounds()
+	dst := image.NewYCbCr(bounds, image.YCbCrSubsampleRatio420)
+
+	rgbImg, isRGBImage := img.(*vnc.RGBImage)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var r, g, b uint32
+			if isRGBImage {
+				c := rgbImg.At(x, y).(color.RGBA)
+				r, g, b = uint32(c.R), uint32(c.G), uint32(c.B)
+			} else {
+				r, g, b, _ = img.At(x, y).RGBA()
+				r, g, b = r>>8, g>>8, b>>8
+			}
+
+			yy, cb, cr := color.RGBToYCbCr(uint8(r), uint8(g), uint8(b))
+
+			yi := dst.YOffset(x, y)
+			dst.Y[yi] = yy
+
+			ci := dst.COffset(x, y)
+			dst.Cb[ci] = cb
+			dst.Cr[ci] = cr
+		}
+	}
+	return dst
+}
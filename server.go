@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// hlsPollInterval controls how often the HLSServer re-checks the playlist on
+// disk while a client is blocked waiting on a not-yet-available segment/part.
+const hlsPollInterval = 100 * time.Millisecond
+
+// hlsReloadTimeout bounds how long a blocking playlist request waits before
+// the server gives up and returns whatever is currently on disk.
+const hlsReloadTimeout = 30 * time.Second
+
+// HLSServer serves the playlist and segments written by HLSEncoder directly
+// over HTTP, so operators can watch the live stream in a browser without
+// standing up a separate web server. It implements the blocking playlist
+// reload convention used by LL-HLS players (the `_HLS_msn`/`_HLS_part` query
+// parameters), so a client long-polling stream.m3u8 gets a response as soon
+// as the requested segment or partial segment is available.
+type HLSServer struct {
+	Addr       string
+	OutputPath string
+
+	srv *http.Server
+}
+
+// NewHLSServer creates an HLSServer that serves files out of outputPath.
+func NewHLSServer(addr, outputPath string) *HLSServer {
+	return &HLSServer{Addr: addr, OutputPath: outputPath}
+}
+
+// Run starts the HTTP server and blocks until it exits or an error occurs.
+func (s *HLSServer) Run() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream.m3u8", s.handlePlaylist)
+	mux.HandleFunc("/", s.handleSegment)
+
+	s.srv = &http.Server{
+		Addr:    s.Addr,
+		Handler: mux,
+	}
+
+	logrus.WithField("addr", s.Addr).Info("Starting HLS HTTP server")
+	err := s.srv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		logrus.WithError(err).Error("HLS HTTP server stopped")
+		return err
+	}
+	return nil
+}
+
+// Close shuts the HTTP server down.
+func (s *HLSServer) Close() {
+	if s.srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Error("error shutting down HLS HTTP server")
+	}
+}
+
+// handlePlaylist serves stream.m3u8, blocking (subject to hlsReloadTimeout)
+// until the media sequence/part requested via _HLS_msn/_HLS_part has landed
+// on disk, per the LL-HLS delivery directives spec.
+func (s *HLSServer) handlePlaylist(w http.ResponseWriter, r *http.Request) {
+	playlistPath := filepath.Join(s.OutputPath, "stream.m3u8")
+
+	wantMSN, hasMSN := parseHLSQueryInt(r, "_HLS_msn")
+	wantPart, _ := parseHLSQueryInt(r, "_HLS_part")
+
+	deadline := time.Now().Add(hlsReloadTimeout)
+	for {
+		body, err := os.ReadFile(playlistPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !hasMSN || playlistHasSequence(string(body), wantMSN, wantPart) || time.Now().After(deadline) {
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Header().Set("Cache-Control", "no-cache")
+			_, _ = w.Write(body)
+			return
+		}
+
+		time.Sleep(hlsPollInterval)
+	}
+}
+
+// handleSegment serves segment (.ts/.m4s) and partial-segment files straight
+// off disk.
+func (s *HLSServer) handleSegment(w http.ResponseWriter, r *http.Request) {
+	name := filepath.Base(r.URL.Path)
+	if name == "." || name == "/" || strings.Contains(name, "..") {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(s.OutputPath, name))
+}
+
+// parseHLSQueryInt reads a numeric LL-HLS query parameter such as _HLS_msn.
+func parseHLSQueryInt(r *http.Request, name string) (int, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return 0, false
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
+
+// playlistHasSequence reports whether the playlist body already contains the
+// requested media sequence (and, for LL-HLS, the requested part within it via
+// an EXT-X-PART tag). #EXT-X-MEDIA-SEQUENCE is the sequence number of the
+// *oldest* segment still listed, so the MSN of the newest complete segment is
+// that plus the number of #EXTINF entries minus one; any in-progress parts
+// after the last #EXTINF belong to the segment after that.
+func playlistHasSequence(playlist string, msn, part int) bool {
+	firstMSN := -1
+	segmentCount := 0
+	lastExtinf := -1
+
+	lines := strings.Split(playlist, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "#EXT-X-MEDIA-SEQUENCE:"):
+			v, err := strconv.Atoi(strings.TrimPrefix(trimmed, "#EXT-X-MEDIA-SEQUENCE:"))
+			if err != nil {
+				return false
+			}
+			firstMSN = v
+		case strings.HasPrefix(trimmed, "#EXTINF:"):
+			segmentCount++
+			lastExtinf = i
+		}
+	}
+	if firstMSN < 0 {
+		return false
+	}
+
+	lastCompleteMSN := firstMSN + segmentCount - 1
+	if msn <= lastCompleteMSN {
+		return true
+	}
+
+	// The segment after the last completed one may still be in progress,
+	// published incrementally as #EXT-X-PART entries.
+	if part == 0 || msn != lastCompleteMSN+1 {
+		return false
+	}
+	partsPublished := 0
+	for _, line := range lines[lastExtinf+1:] {
+		if strings.HasPrefix(strings.TrimSpace(line), "#EXT-X-PART:") {
+			partsPublished++
+		}
+	}
+	return partsPublished >= part
+}